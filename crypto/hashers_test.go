@@ -0,0 +1,69 @@
+package crypto
+
+import (
+	"crypto/sha256"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestHasherNegotiationAcrossAlgorithms(t *testing.T) {
+	signer := &MessageVerifier{Secret: []byte("secret"), Serializer: jsonMsgSerializer{}, HasherName: "sha256"}
+	token, err := signer.Generate("payload")
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	if !strings.Contains(token, "sha256:") {
+		t.Fatalf("expected token digest to be tagged with sha256:, got %q", token)
+	}
+
+	// A verifier configured with a different default Hasher (sha1) should
+	// still verify the token correctly by negotiating off the tag.
+	verifier := &MessageVerifier{Secret: []byte("secret"), Serializer: jsonMsgSerializer{}}
+	var out string
+	if err := verifier.Verify(token, &out); err != nil {
+		t.Fatalf("Verify failed to negotiate tagged hasher: %v", err)
+	}
+	if out != "payload" {
+		t.Fatalf("got %q, want %q", out, "payload")
+	}
+}
+
+func TestVerifyRejectsUnknownTaggedHasher(t *testing.T) {
+	v := &MessageVerifier{Secret: []byte("secret"), Serializer: jsonMsgSerializer{}}
+	token, err := v.Generate("payload")
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+
+	parts := strings.Split(token, "--")
+	tampered := parts[0] + "--notreal:" + parts[1]
+	var out string
+	if err := v.Verify(tampered, &out); err == nil {
+		t.Fatalf("expected Verify to reject an unregistered tagged hasher")
+	}
+}
+
+func TestRegisterHasherConcurrentWithGenerate(t *testing.T) {
+	// Hasher is set up front so concurrent Generate() calls don't race on
+	// checkInit()'s one-time "default to sha1" assignment to it; that's a
+	// separate, pre-existing MessageVerifier field and not what this test
+	// is about.
+	v := &MessageVerifier{Secret: []byte("secret"), Serializer: jsonMsgSerializer{}, HasherName: "sha256", Hasher: sha256.New}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			RegisterHasher("custom-sha256", sha256.New)
+		}(i)
+		go func() {
+			defer wg.Done()
+			if _, err := v.Generate("payload"); err != nil {
+				t.Errorf("Generate: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+}
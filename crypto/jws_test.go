@@ -0,0 +1,106 @@
+package crypto
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJWSGenerateVerifyRoundTrip(t *testing.T) {
+	v := &MessageVerifier{Secret: []byte("jws-secret"), Serializer: jsonMsgSerializer{}, Format: FormatJWSCompact}
+
+	token, err := v.Generate("remember-me")
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	if strings.Count(token, ".") != 2 {
+		t.Fatalf("expected header.payload.signature, got %q", token)
+	}
+
+	var out string
+	if err := v.Verify(token, &out); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if out != "remember-me" {
+		t.Fatalf("got %q, want %q", out, "remember-me")
+	}
+}
+
+func TestJWSVerifyRejectsTamperedPayload(t *testing.T) {
+	v := &MessageVerifier{Secret: []byte("jws-secret"), Serializer: jsonMsgSerializer{}, Format: FormatJWSCompact}
+	token, err := v.Generate("remember-me")
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+
+	parts := strings.Split(token, ".")
+	parts[1] = base64.RawURLEncoding.EncodeToString([]byte(`"tampered"`))
+	tampered := strings.Join(parts, ".")
+
+	var out string
+	if err := v.Verify(tampered, &out); err == nil {
+		t.Fatalf("expected tampered JWS payload to fail verification")
+	}
+}
+
+func TestJWSVerifyRejectsAlgConfusion(t *testing.T) {
+	v := &MessageVerifier{Secret: []byte("jws-secret"), Serializer: jsonMsgSerializer{}, Format: FormatJWSCompact}
+	token, err := v.Generate("remember-me")
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	parts := strings.Split(token, ".")
+
+	noneHeader, _ := json.Marshal(joseHeader{Alg: "none", Typ: "JWT"})
+	noneToken := base64.RawURLEncoding.EncodeToString(noneHeader) + "." + parts[1] + "."
+
+	var out string
+	if err := v.Verify(noneToken, &out); err == nil {
+		t.Fatalf("expected alg=none token to be rejected")
+	}
+
+	hs384Header, _ := json.Marshal(joseHeader{Alg: "HS384", Typ: "JWT"})
+	mismatchToken := base64.RawURLEncoding.EncodeToString(hs384Header) + "." + parts[1] + "." + parts[2]
+	if err := v.Verify(mismatchToken, &out); err == nil {
+		t.Fatalf("expected a token whose alg doesn't match the configured hasher to be rejected")
+	}
+}
+
+func TestJWSVerifyEnforcesExpiry(t *testing.T) {
+	v := &MessageVerifier{Secret: []byte("jws-secret"), Serializer: jsonMsgSerializer{}, Format: FormatJWSCompact}
+
+	type claims struct {
+		Exp int64 `json:"exp"`
+	}
+	token, err := v.Generate(claims{Exp: time.Now().Add(-time.Minute).Unix()})
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+
+	var out claims
+	if err := v.Verify(token, &out); err != ErrExpired {
+		t.Fatalf("got err %v, want ErrExpired", err)
+	}
+}
+
+func TestJWSSignVerifyUsesKeyring(t *testing.T) {
+	v := &MessageVerifier{Serializer: jsonMsgSerializer{}, Format: FormatJWSCompact}
+	v.Rotate("k1", []byte("first-secret"))
+
+	token, err := v.Generate("remember-me")
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+
+	v.Rotate("k2", []byte("second-secret"))
+
+	var out string
+	if err := v.Verify(token, &out); err != nil {
+		t.Fatalf("Verify of pre-rotation JWS token failed during rotation window: %v", err)
+	}
+	if out != "remember-me" {
+		t.Fatalf("got %q, want %q", out, "remember-me")
+	}
+}
@@ -0,0 +1,34 @@
+package crypto
+
+import (
+	"crypto/sha1"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// defaultKeyGeneratorIterations mirrors the iteration count
+// ActiveSupport::KeyGenerator has shipped with historically.
+const defaultKeyGeneratorIterations = 1000
+
+// KeyGenerator derives fixed-length secret keys from a single master
+// secret (typically a Rails app's secret_key_base) using PBKDF2-HMAC-SHA1,
+// so a Go process can independently derive the same Secret/SignSecret a
+// Rails app used to encrypt a cookie.
+type KeyGenerator struct {
+	// Secret is the master secret all derived keys are generated from.
+	Secret []byte
+	// Iterations defaults to 1000 if not set.
+	Iterations int
+}
+
+// GenerateKey derives a keyLen-byte key from the KeyGenerator's Secret and
+// the given salt. Different salts applied to the same Secret yield
+// independent keys, which is how a single secret_key_base can back both a
+// MessageEncryptor's Secret and SignSecret.
+func (kg *KeyGenerator) GenerateKey(salt []byte, keyLen int) []byte {
+	iterations := kg.Iterations
+	if iterations == 0 {
+		iterations = defaultKeyGeneratorIterations
+	}
+	return pbkdf2.Key(kg.Secret, salt, iterations, keyLen, sha1.New)
+}
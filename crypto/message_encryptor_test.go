@@ -0,0 +1,136 @@
+package crypto
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMessageEncryptorGCMRoundTrip(t *testing.T) {
+	enc := &MessageEncryptor{Secret: make([]byte, 32), Serializer: jsonMsgSerializer{}}
+
+	tok, err := enc.Encrypt("top-secret")
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	if strings.Count(tok, "--") != 2 {
+		t.Fatalf("expected ciphertext--iv--tag token, got %q", tok)
+	}
+
+	var out string
+	if err := enc.Decrypt(tok, &out); err != nil {
+		t.Fatalf("Decrypt: %v", err)
+	}
+	if out != "top-secret" {
+		t.Fatalf("got %q, want %q", out, "top-secret")
+	}
+}
+
+func TestMessageEncryptorGCMRejectsTamperedCiphertext(t *testing.T) {
+	enc := &MessageEncryptor{Secret: make([]byte, 32), Serializer: jsonMsgSerializer{}}
+	tok, err := enc.Encrypt("top-secret")
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+
+	parts := strings.Split(tok, "--")
+	parts[0] = parts[0] + "AA"
+	tampered := strings.Join(parts, "--")
+
+	var out string
+	if err := enc.Decrypt(tampered, &out); err == nil {
+		t.Fatalf("expected tampered ciphertext to fail authentication")
+	}
+}
+
+func TestMessageEncryptorCBCRoundTrip(t *testing.T) {
+	enc := &MessageEncryptor{
+		Secret:     make([]byte, 32),
+		SignSecret: []byte("signsecret"),
+		Serializer: jsonMsgSerializer{},
+		Cipher:     CipherAES256CBC,
+	}
+
+	tok, err := enc.Encrypt("cbc-value")
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	if strings.Count(tok, "--") != 2 {
+		t.Fatalf("expected ciphertext--iv--digest token, got %q", tok)
+	}
+
+	var out string
+	if err := enc.Decrypt(tok, &out); err != nil {
+		t.Fatalf("Decrypt: %v", err)
+	}
+	if out != "cbc-value" {
+		t.Fatalf("got %q, want %q", out, "cbc-value")
+	}
+}
+
+func TestMessageEncryptorCBCRejectsBadDigest(t *testing.T) {
+	enc := &MessageEncryptor{
+		Secret:     make([]byte, 32),
+		SignSecret: []byte("signsecret"),
+		Serializer: jsonMsgSerializer{},
+		Cipher:     CipherAES256CBC,
+	}
+	tok, err := enc.Encrypt("cbc-value")
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+
+	parts := strings.SplitN(tok, "--", 3)
+	parts[2] = "0" + parts[2]
+	tampered := strings.Join(parts, "--")
+
+	var out string
+	if err := enc.Decrypt(tampered, &out); err == nil {
+		t.Fatalf("expected bad digest to fail verification")
+	}
+}
+
+func TestKeyGeneratorDeterministic(t *testing.T) {
+	kg := &KeyGenerator{Secret: []byte("the-rails-secret_key_base")}
+
+	secret := kg.GenerateKey([]byte("encrypted cookie"), 32)
+	again := kg.GenerateKey([]byte("encrypted cookie"), 32)
+	if string(secret) != string(again) {
+		t.Fatalf("expected GenerateKey to be deterministic for the same secret/salt")
+	}
+	if len(secret) != 32 {
+		t.Fatalf("expected a 32-byte key, got %d bytes", len(secret))
+	}
+
+	signSecret := kg.GenerateKey([]byte("signed encrypted cookie"), 64)
+	if len(signSecret) != 64 {
+		t.Fatalf("expected a 64-byte key, got %d bytes", len(signSecret))
+	}
+	if string(secret) == string(signSecret[:32]) {
+		t.Fatalf("expected different salts to derive independent keys")
+	}
+}
+
+func TestKeyGeneratorDerivedKeysDecryptCookie(t *testing.T) {
+	kg := &KeyGenerator{Secret: []byte("shared-secret-key-base")}
+	secret := kg.GenerateKey([]byte("encrypted cookie"), 32)
+	signSecret := kg.GenerateKey([]byte("signed encrypted cookie"), 64)
+
+	enc := &MessageEncryptor{
+		Secret:     secret,
+		SignSecret: signSecret,
+		Serializer: jsonMsgSerializer{},
+		Cipher:     CipherAES256CBC,
+	}
+	tok, err := enc.Encrypt("session-payload")
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+
+	var out string
+	if err := enc.Decrypt(tok, &out); err != nil {
+		t.Fatalf("Decrypt with KeyGenerator-derived keys: %v", err)
+	}
+	if out != "session-payload" {
+		t.Fatalf("got %q, want %q", out, "session-payload")
+	}
+}
@@ -9,20 +9,94 @@ import (
 	"fmt"
 	"hash"
 	"strings"
+	"sync"
+	"time"
 )
 
+// ErrExpired is returned by VerifyWithOptions() when the message's
+// ExpiresAt has passed.
+var ErrExpired = errors.New("crypto: message has expired")
+
+// ErrPurposeMismatch is returned by VerifyWithOptions() when the message
+// was generated for a different Purpose than the one being verified
+// against.
+var ErrPurposeMismatch = errors.New("crypto: message purpose mismatch")
+
+// VerifierOptions carries the expiry and purpose metadata that
+// GenerateWithOptions() embeds into the signed payload, and that
+// VerifyWithOptions() checks on the way out.
+type VerifierOptions struct {
+	// ExpiresAt, if set, is embedded in the message and checked by
+	// VerifyWithOptions(); a zero value means the message never expires.
+	ExpiresAt time.Time
+	// Purpose, if set, is embedded in the message and must match the
+	// Purpose passed to VerifyWithOptions(), so a token generated for one
+	// purpose (e.g. "password_reset") can't be replayed for another (e.g.
+	// "email_confirmation").
+	Purpose string
+	// Now defaults to time.Now; override it in tests to control expiry
+	// checks deterministically.
+	Now func() time.Time
+}
+
+// envelope wraps a caller's value together with the metadata needed to
+// enforce expiry and purpose scoping. It is what actually gets signed by
+// GenerateWithOptions()/VerifyWithOptions().
+//
+// Data holds the value already serialized by crypt.Serializer, not the raw
+// value itself: decoding it generically as interface{} (e.g. through a
+// JSON Serializer, where numbers land as float64) would lose precision on
+// anything outside float64's exact integer range, such as snowflake IDs or
+// nanosecond timestamps. Carrying it as an opaque string sidesteps that;
+// it's unserialized straight into the caller's target by
+// unserializeWithQuoteFallback, same as Verify() does for the plain format.
+type envelope struct {
+	Data      string    `json:"data"`
+	ExpiresAt time.Time `json:"expires_at,omitempty"`
+	Purpose   string    `json:"purpose,omitempty"`
+}
+
+// Keyring maps key IDs to the secret bytes they represent, so a
+// MessageVerifier can verify tokens signed with a previous secret while
+// generating new ones with the current secret.
+type Keyring map[string][]byte
+
 // MessageVerifier makes it easy to generate and verify messages which are
 // signed to prevent tampering.
 //
 // This is useful for cases like remember-me tokens and auto-unsubscribe links
 // where the session store isn't suitable or available.
 type MessageVerifier struct {
-	// Secret of 32-bytes if using the default hashing.
+	// Secret of 32-bytes if using the default hashing. Generate() ignores it
+	// once Keyring is set, but Verify() still checks tokens without a key ID
+	// against it; kept so existing single-secret callers keep working as-is.
 	Secret []byte
-	// Hasher defaults to sha1 if not set.
+	// Keyring holds the set of secrets this verifier knows about, keyed by
+	// key ID. When set, Generate() signs with ActiveKeyID and Verify()
+	// accepts a token signed with any key still present in the Keyring,
+	// which is what makes secret rotation possible without invalidating
+	// outstanding tokens.
+	Keyring Keyring
+	// ActiveKeyID is the Keyring key used by Generate(). Required when
+	// Keyring is set.
+	ActiveKeyID string
+	// keyringMu guards Keyring/ActiveKeyID so Rotate() can run on a live
+	// verifier concurrently with Generate()/Verify() calls without
+	// tripping the race detector or a concurrent-map-write panic.
+	keyringMu sync.RWMutex
+	// Hasher defaults to sha1 if not set. Generate() ignores it once
+	// HasherName is set, but Verify() still uses it for untagged digests.
 	Hasher func() hash.Hash
+	// HasherName selects a hasher from the RegisterHasher registry by name
+	// and, unlike Hasher, tags Generate()'s output with that name so a
+	// later Verify() (possibly after the default has moved on to a
+	// stronger algorithm) knows which hasher to use. Leave unset to keep
+	// the old untagged behavior driven by Hasher.
+	HasherName string
 	// Serializer defines the way the data is serializer/deserialized.
 	Serializer MsgSerializer
+	// Format selects the wire format; defaults to FormatRailsDoubleDash.
+	Format TokenFormat
 }
 
 // Checks that the struct is properly set and ready for use.
@@ -34,7 +108,57 @@ func (crypt *MessageVerifier) IsValid() (bool, error) {
 	return true, nil
 }
 
+// Rotate adds (or replaces) a secret under newID and makes it the active key
+// used by Generate(). Previously registered keys are left in the Keyring, so
+// tokens signed before the rotation keep verifying until they expire or the
+// old key is removed explicitly.
+func (crypt *MessageVerifier) Rotate(newID string, newSecret []byte) {
+	crypt.keyringMu.Lock()
+	defer crypt.keyringMu.Unlock()
+	if crypt.Keyring == nil {
+		crypt.Keyring = Keyring{}
+	}
+	crypt.Keyring[newID] = newSecret
+	crypt.ActiveKeyID = newID
+}
+
+// hasKeyring reports whether a Keyring has been configured, guarded by
+// keyringMu so it's safe to call concurrently with Rotate().
+func (crypt *MessageVerifier) hasKeyring() bool {
+	crypt.keyringMu.RLock()
+	defer crypt.keyringMu.RUnlock()
+	return crypt.Keyring != nil
+}
+
+// lookupSecret returns the secret registered under keyID, guarded by
+// keyringMu so it's safe to call concurrently with Rotate().
+func (crypt *MessageVerifier) lookupSecret(keyID string) ([]byte, bool) {
+	crypt.keyringMu.RLock()
+	defer crypt.keyringMu.RUnlock()
+	secret, ok := crypt.Keyring[keyID]
+	return secret, ok
+}
+
+// activeSecret returns the ActiveKeyID and its secret, guarded by
+// keyringMu so it's safe to call concurrently with Rotate().
+func (crypt *MessageVerifier) activeSecret() (secret []byte, keyID string, ok bool) {
+	crypt.keyringMu.RLock()
+	defer crypt.keyringMu.RUnlock()
+	keyID = crypt.ActiveKeyID
+	secret, ok = crypt.Keyring[keyID]
+	return secret, keyID, ok
+}
+
+// activeKeyID returns ActiveKeyID, guarded by keyringMu so it's safe to
+// call concurrently with Rotate().
+func (crypt *MessageVerifier) activeKeyID() string {
+	crypt.keyringMu.RLock()
+	defer crypt.keyringMu.RUnlock()
+	return crypt.ActiveKeyID
+}
+
 // Verify() takes a base64 encoded message string joined to a digest by a double dash "--"
+// (optionally prefixed with a "keyid--" segment when a Keyring is in use)
 // and returns an error if anything wrong happen.
 // If the verification worked, the target interface object passed is populated.
 func (crypt *MessageVerifier) Verify(msg string, target interface{}) error {
@@ -44,34 +168,112 @@ func (crypt *MessageVerifier) Verify(msg string, target interface{}) error {
 		return err
 	}
 
+	if crypt.Format == FormatJWSCompact {
+		return crypt.verifyJWS(msg, target)
+	}
+
+	decodedData, err := crypt.verifyRaw(msg)
+	if err != nil {
+		return err
+	}
+
+	return crypt.unserializeWithQuoteFallback(string(decodedData), target)
+}
+
+// verifyRaw dispatches to verifyDigest() or verifyJWSRaw() based on
+// crypt.Format and returns the still-serialized payload bytes, without
+// unserializing them into a caller-supplied target. It's the shared tail
+// end of Verify() and VerifyWithOptions(), so Format is honored the same
+// way regardless of which method the caller used.
+func (crypt *MessageVerifier) verifyRaw(msg string) ([]byte, error) {
+	if crypt.Format == FormatJWSCompact {
+		return crypt.verifyJWSRaw(msg)
+	}
+	return crypt.verifyDigest(msg)
+}
+
+// unserializeWithQuoteFallback tries to unserialize data into target
+// wrapped in double quotes first, then again as-is. Some serializers
+// round-trip a bare string value unquoted, so both forms need a chance
+// before giving up.
+func (crypt *MessageVerifier) unserializeWithQuoteFallback(data string, target interface{}) error {
+	err := crypt.Serializer.Unserialize("\""+data+"\"", target)
+	if err != nil {
+		err = crypt.Serializer.Unserialize(data, target)
+		if err != nil {
+			return fmt.Errorf("failed to unserialize both quoted and raw data: %w", err)
+		}
+	}
+	return nil
+}
+
+// verifyDigest validates the digest of msg (handling both the plain
+// "data--digest" format and the keyid-qualified "keyid--data--digest"
+// format) and returns the base64-decoded, still-serialized payload.
+func (crypt *MessageVerifier) verifyDigest(msg string) ([]byte, error) {
 	invalid := func(msg string) error {
 		return errors.New("Invalid signature - " + msg)
 	}
 	if msg == "" {
-		return invalid("empty message")
+		return nil, invalid("empty message")
 	}
 
-	dataDigest := strings.Split(msg, "--")
-	if len(dataDigest) != 2 {
-		return invalid("bad data --")
+	parts := strings.Split(msg, "--")
+
+	var data, digest string
+	var secret []byte
+
+	switch len(parts) {
+	case 2:
+		// Backward-compat format: no keyid present, fall back to Secret.
+		data, digest = parts[0], parts[1]
+		secret = crypt.Secret
+	case 3:
+		keyID := parts[0]
+		data, digest = parts[1], parts[2]
+		if !crypt.hasKeyring() {
+			return nil, invalid("no keyring configured for keyid token")
+		}
+		var ok bool
+		secret, ok = crypt.lookupSecret(keyID)
+		if !ok {
+			return nil, invalid("unknown key id")
+		}
+	default:
+		return nil, invalid("bad data --")
 	}
 
-	data, digest := dataDigest[0], dataDigest[1]
-	if crypt.secureCompare(digest, crypt.DigestFor(data)) == false {
-		return invalid("bad data (compare)")
+	if secret == nil {
+		return nil, invalid("no secret for key id")
 	}
-	decodedData, _ := base64.StdEncoding.Strict().DecodeString(data)
-  decodedString := "\"" + string(decodedData) + "\""
-	err = crypt.Serializer.Unserialize(string(decodedString), target)
 
-  if err != nil {
-    err = crypt.Serializer.Unserialize(string(decodedData), target)
-    if err != nil {
-      return fmt.Errorf("failed to unserialize both quoted and raw data: %w", err)
-    }
-  }
+	algo, hexDigest, tagged := splitAlgoDigest(digest)
+	factory := crypt.Hasher
+	if tagged {
+		f, ok := lookupHasher(algo)
+		if !ok {
+			return nil, invalid("unknown hash algorithm " + algo)
+		}
+		factory = f
+	}
 
-	return err
+	if crypt.secureCompare(hexDigest, crypt.digestWithSecret(data, secret, factory)) == false {
+		return nil, invalid("bad data (compare)")
+	}
+	decodedData, _ := base64.StdEncoding.Strict().DecodeString(data)
+	return decodedData, nil
+}
+
+// splitAlgoDigest splits a digest field tagged as "algo:hexdigest" by
+// Generate() back into its parts. Untagged digests (the pre-existing
+// format, always hex with no colon) report tagged=false so callers fall
+// back to the configured Hasher.
+func splitAlgoDigest(digest string) (algo, hexDigest string, tagged bool) {
+	idx := strings.Index(digest, ":")
+	if idx < 0 {
+		return "", digest, false
+	}
+	return digest[:idx], digest[idx+1:], true
 }
 
 // Generate() Converts an interface into a string containing the serialized data
@@ -84,15 +286,121 @@ func (crypt *MessageVerifier) Generate(value interface{}) (string, error) {
 		return "", err
 	}
 
+	return crypt.signValue(value)
+}
+
+// signValue dispatches to sign() or signJWS() based on crypt.Format. It's
+// the shared tail end of Generate() and GenerateWithOptions(), so Format
+// is honored the same way regardless of which method the caller used.
+func (crypt *MessageVerifier) signValue(value interface{}) (string, error) {
+	if crypt.Format == FormatJWSCompact {
+		return crypt.signJWS(value)
+	}
+	return crypt.sign(value)
+}
+
+// sign serializes value and produces the "[keyid--]data--digest" token for
+// it. It is the shared tail end of Generate() and GenerateWithOptions().
+//
+// When HasherName is set, the digest is tagged as "algo:hexdigest" instead
+// of a bare hex digest, so Verify() can negotiate the algorithm a token was
+// signed with. That's folded into the existing digest field rather than
+// added as its own "--"-separated segment so it composes with the
+// keyid-qualified format from Keyring/Rotate() instead of fighting it over
+// how many "--" segments a token has.
+func (crypt *MessageVerifier) sign(value interface{}) (string, error) {
 	data, err := crypt.Serializer.Serialize(value)
 	if err != nil {
 		return "", err
 	}
 	str := base64.StdEncoding.EncodeToString([]byte(data))
-	digest := crypt.DigestFor(str)
+
+	factory := crypt.Hasher
+	algo := ""
+	if crypt.HasherName != "" {
+		f, ok := lookupHasher(crypt.HasherName)
+		if !ok {
+			return "", fmt.Errorf("crypto: unknown hasher %q", crypt.HasherName)
+		}
+		factory, algo = f, crypt.HasherName
+	}
+
+	tag := func(digest string) string {
+		if algo == "" {
+			return digest
+		}
+		return algo + ":" + digest
+	}
+
+	if crypt.hasKeyring() {
+		secret, keyID, ok := crypt.activeSecret()
+		if !ok {
+			return "", errors.New("ActiveKeyID not found in Keyring")
+		}
+		digest := tag(crypt.digestWithSecret(str, secret, factory))
+		return fmt.Sprintf("%s--%s--%s", keyID, str, digest), nil
+	}
+
+	digest := tag(crypt.digestWithSecret(str, crypt.Secret, factory))
 	return fmt.Sprintf("%s--%s", str, digest), nil
 }
 
+// GenerateWithOptions behaves like Generate() but embeds opts.ExpiresAt and
+// opts.Purpose into the signed payload, so the resulting token is safe to
+// use for things like password resets and email confirmations where a
+// stolen but expired (or wrongly-scoped) token shouldn't verify.
+func (crypt *MessageVerifier) GenerateWithOptions(value interface{}, opts VerifierOptions) (string, error) {
+	err := crypt.checkInit()
+	if err != nil {
+		return "", err
+	}
+
+	data, err := crypt.Serializer.Serialize(value)
+	if err != nil {
+		return "", err
+	}
+
+	env := envelope{
+		Data:      data,
+		ExpiresAt: opts.ExpiresAt,
+		Purpose:   opts.Purpose,
+	}
+	return crypt.signValue(env)
+}
+
+// VerifyWithOptions verifies msg like Verify() does, then additionally
+// checks the embedded expiry and purpose against opts, returning ErrExpired
+// or ErrPurposeMismatch so callers can distinguish expiry from tampering.
+func (crypt *MessageVerifier) VerifyWithOptions(msg string, target interface{}, opts VerifierOptions) error {
+	err := crypt.checkInit()
+	if err != nil {
+		return err
+	}
+
+	decodedData, err := crypt.verifyRaw(msg)
+	if err != nil {
+		return err
+	}
+
+	var env envelope
+	if err := crypt.Serializer.Unserialize(string(decodedData), &env); err != nil {
+		return fmt.Errorf("failed to unserialize envelope: %w", err)
+	}
+
+	now := opts.Now
+	if now == nil {
+		now = time.Now
+	}
+	if !env.ExpiresAt.IsZero() && now().After(env.ExpiresAt) {
+		return ErrExpired
+	}
+	if env.Purpose != opts.Purpose {
+		return ErrPurposeMismatch
+	}
+
+	return crypt.unserializeWithQuoteFallback(env.Data, target)
+}
+
 // DigestFor returns the digest form of a string after hashing it via
 // the verifier's digest and secret.
 func (crypt *MessageVerifier) DigestFor(data string) string {
@@ -100,7 +408,15 @@ func (crypt *MessageVerifier) DigestFor(data string) string {
 		return "Y U SET NO SECRET???!"
 	}
 
-	mac := hmac.New(crypt.Hasher, crypt.Secret)
+	return crypt.digestWithSecret(data, crypt.Secret, crypt.Hasher)
+}
+
+// digestWithSecret hashes data with an explicit secret and hasher factory,
+// so Verify() can pick the right key out of the Keyring and the right
+// algorithm out of the hasherRegistry instead of always using crypt.Secret
+// and crypt.Hasher.
+func (crypt *MessageVerifier) digestWithSecret(data string, secret []byte, factory func() hash.Hash) string {
+	mac := hmac.New(factory, secret)
 	mac.Write([]byte(data))
 	return hex.EncodeToString(mac.Sum(nil))
 }
@@ -133,6 +449,19 @@ func (crypt *MessageVerifier) checkInit() error {
 		crypt.Hasher = sha1.New
 	}
 
+	if crypt.HasherName != "" {
+		if _, ok := lookupHasher(crypt.HasherName); !ok {
+			return fmt.Errorf("crypto: unknown hasher %q", crypt.HasherName)
+		}
+	}
+
+	if crypt.hasKeyring() {
+		if crypt.activeKeyID() == "" {
+			return errors.New("ActiveKeyID not set")
+		}
+		return nil
+	}
+
 	if crypt.Secret == nil {
 		return errors.New("Secret not set")
 	}